@@ -0,0 +1,139 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1alpha1 contains the ClusterIdentity custom resource, which
+// replaces the legacy "ingress-uid" config map as the source of truth for
+// a cluster's identity and its membership in a federation.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ClusterIdentityPhase is the lifecycle phase of a ClusterIdentity.
+type ClusterIdentityPhase string
+
+const (
+	// ClusterIdentityStandalone means the cluster has not joined (or has
+	// fully left) a federation.
+	ClusterIdentityStandalone ClusterIdentityPhase = "Standalone"
+	// ClusterIdentityJoining means a join to a federation has been
+	// requested but has not yet been observed as complete.
+	ClusterIdentityJoining ClusterIdentityPhase = "Joining"
+	// ClusterIdentityFederated means the cluster is a current member of
+	// the federation named in Spec.FederationUID.
+	ClusterIdentityFederated ClusterIdentityPhase = "Federated"
+	// ClusterIdentityLeaving means a leave from the current federation
+	// has been requested but has not yet been observed as complete.
+	ClusterIdentityLeaving ClusterIdentityPhase = "Leaving"
+)
+
+// FederationMemberRole describes the role a cluster plays within a
+// federation.
+type FederationMemberRole string
+
+const (
+	// FederationMemberPrimary is the cluster that originated the
+	// federation.
+	FederationMemberPrimary FederationMemberRole = "Primary"
+	// FederationMemberSecondary is a cluster that joined an
+	// already-existing federation.
+	FederationMemberSecondary FederationMemberRole = "Secondary"
+)
+
+// FederationMember records a single cluster's membership in a federation.
+type FederationMember struct {
+	// UID is the ClusterUID of the member cluster.
+	UID string `json:"uid"`
+	// Role is the role this member plays in the federation.
+	Role FederationMemberRole `json:"role"`
+	// JoinedAt is when this member was observed to join the federation.
+	JoinedAt metav1.Time `json:"joinedAt"`
+}
+
+// ClusterIdentitySpec is the desired state of a cluster's identity.
+type ClusterIdentitySpec struct {
+	// ClusterUID uniquely identifies this cluster. It is immutable once
+	// set. It is migrated from the legacy "ingress-uid" config map when one
+	// exists, and otherwise generated randomly or by fingerprint derivation.
+	ClusterUID string `json:"clusterUID"`
+	// FederationUID identifies the federation this cluster belongs to, if
+	// any. An empty value means the cluster is Standalone.
+	FederationUID string `json:"federationUID,omitempty"`
+	// Members is the set of clusters known to participate in
+	// FederationUID. It is only meaningful when FederationUID is set.
+	Members []FederationMember `json:"members,omitempty"`
+}
+
+// ClusterIdentityStatus is the observed state of a cluster's identity, as
+// reconciled by the cloud provider.
+type ClusterIdentityStatus struct {
+	// Phase is the current lifecycle phase of the cluster identity.
+	Phase ClusterIdentityPhase `json:"phase,omitempty"`
+	// Message is a human readable explanation of the current Phase,
+	// populated on transitions and errors.
+	Message string `json:"message,omitempty"`
+}
+
+// ClusterIdentity is the source of truth for a cluster's UID and its
+// membership in a federation. It replaces the "ingress-uid" config map.
+type ClusterIdentity struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterIdentitySpec   `json:"spec,omitempty"`
+	Status ClusterIdentityStatus `json:"status,omitempty"`
+}
+
+// ClusterIdentityList is a list of ClusterIdentity resources.
+type ClusterIdentityList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ClusterIdentity `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *ClusterIdentity) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterIdentity)
+	*out = *in
+	out.ObjectMeta = *in.ObjectMeta.DeepCopy()
+	if in.Spec.Members != nil {
+		out.Spec.Members = make([]FederationMember, len(in.Spec.Members))
+		copy(out.Spec.Members, in.Spec.Members)
+	}
+	return out
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *ClusterIdentityList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterIdentityList)
+	*out = *in
+	if in.Items != nil {
+		out.Items = make([]ClusterIdentity, len(in.Items))
+		for i := range in.Items {
+			out.Items[i] = *in.Items[i].DeepCopyObject().(*ClusterIdentity)
+		}
+	}
+	return out
+}
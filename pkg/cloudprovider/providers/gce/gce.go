@@ -0,0 +1,75 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
+)
+
+// ClientBuilder is the subset of controller.ControllerClientBuilder that
+// the ClusterIdentity support in this package needs: a clientset for the
+// "cloud-provider" service account, and the rest.Config backing it so a
+// CRD-scoped dynamic client (and, for leader election, a client-go
+// clientset) can be built on demand.
+type ClientBuilder interface {
+	ClientOrDie(name string) clientset.Interface
+	ConfigOrDie(name string) *restclient.Config
+}
+
+// ConfigGlobal holds the "[Global]" section of the GCE cloud provider's
+// ini-style config file.
+type ConfigGlobal struct {
+	// ClusterIDEventsSink is the CloudEvents HTTP sink URL CloudEvents
+	// describing cluster identity lifecycle transitions are POSTed to.
+	// Configured via "clusterid-events-sink". Optional; no events are
+	// published when unset.
+	ClusterIDEventsSink string `gcfg:"clusterid-events-sink"`
+
+	// ClusterIDDerivation selects how getOrInitialize picks a brand-new
+	// ClusterUID: "random" (the default) or "fingerprint", per
+	// ClusterIdDerivationMode. Configured via "clusterid-derivation".
+	ClusterIDDerivation string `gcfg:"clusterid-derivation"`
+
+	// ClusterIDKeySecret is the "<namespace>/<name>" Secret that holds the
+	// HMAC key material for "fingerprint" derivation. Configured via
+	// "clusterid-key-secret"; required when ClusterIDDerivation is
+	// "fingerprint", ignored otherwise.
+	ClusterIDKeySecret string `gcfg:"clusterid-key-secret"`
+}
+
+// ConfigFile is the parsed form of the GCE cloud provider's config file.
+type ConfigFile struct {
+	Global ConfigGlobal `gcfg:"global"`
+}
+
+// GCECloud is the GCE implementation of cloudprovider.Interface. Only the
+// fields the ClusterIdentity support in gce_clusterid*.go depends on are
+// declared here.
+type GCECloud struct {
+	clientBuilder ClientBuilder
+	config        *ConfigFile
+
+	projectID   string
+	region      string
+	clusterName string
+	networkURL  string
+
+	// ClusterId is this cluster's reconciled identity: its UID, and (if
+	// federated) its federation membership.
+	ClusterId ClusterId
+}
@@ -17,20 +17,32 @@ limitations under the License.
 package gce
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
 	"reflect"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	clientgokubernetes "k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	clusteridentity "k8s.io/kubernetes/pkg/apis/clusteridentity/v1alpha1"
 	"k8s.io/kubernetes/pkg/api/v1"
 	"k8s.io/kubernetes/pkg/client/clientset_generated/clientset"
 )
@@ -47,24 +59,183 @@ const (
 	// Frequency of the updateFunc event handler being called
 	// This does not actually query the apiserver for current state - the local cache value is used.
 	updateFuncFrequency = 10 * time.Minute
+
+	// ClusterIdentityName is the name of the singleton ClusterIdentity
+	// resource that replaces the "ingress-uid" config map. It is
+	// cluster-scoped, so there is exactly one instance per cluster.
+	ClusterIdentityName = "cluster-identity"
+
+	// clusterIdEventQueueLen bounds the fan-out channel so a slow or
+	// absent consumer cannot block informer event handlers.
+	clusterIdEventQueueLen = 32
+
+	// clusterIdInitLeaseName is the Lease used to serialize ClusterIdentity
+	// creation across racing kube-controller-manager replicas / HA masters.
+	clusterIdInitLeaseName = "ingress-uid-init"
+
+	// clusterIdInitElectionTimeout bounds each of createUnderLeaderElection's
+	// two waits in turn: to win the ingress-uid-init lease itself, and then,
+	// if it did not win, to observe the winner's ClusterIdentity through the
+	// informer. It must never block indefinitely: GetId()/getOrInitialize()
+	// run on this call path.
+	clusterIdInitElectionTimeout = 20 * time.Second
+
+	// informerLagReportInterval is how often gce_clusterid_informer_lag_seconds
+	// is refreshed.
+	informerLagReportInterval = 15 * time.Second
+)
+
+var (
+	clusterIdInitAttemptsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gce_clusterid_init_attempts_total",
+		Help: "Number of times this process attempted to initialize the cluster's ClusterIdentity.",
+	})
+	clusterIdInitConflictsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gce_clusterid_init_conflicts_total",
+		Help: "Number of times ClusterIdentity initialization observed an AlreadyExists and adopted the existing value instead of failing.",
+	})
+	clusterIdInformerLagSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gce_clusterid_informer_lag_seconds",
+		Help: "Seconds since the ClusterIdentity informer last observed an update.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(clusterIdInitAttemptsTotal)
+	prometheus.MustRegister(clusterIdInitConflictsTotal)
+	prometheus.MustRegister(clusterIdInformerLagSeconds)
+}
+
+// ClusterIdEventType identifies the kind of transition a ClusterIdEvent
+// reports.
+type ClusterIdEventType string
+
+const (
+	// IdentityChanged fires whenever the reconciled ClusterUID changes,
+	// including when it is first observed. A change of FederationUID alone
+	// is reported only through FederationJoined/FederationLeft.
+	IdentityChanged ClusterIdEventType = "IdentityChanged"
+	// FederationJoined fires when FederationUID transitions from unset
+	// to set.
+	FederationJoined ClusterIdEventType = "FederationJoined"
+	// FederationLeft fires when FederationUID transitions from set to
+	// unset.
+	FederationLeft ClusterIdEventType = "FederationLeft"
 )
 
+// ClusterIdEvent describes a single observed transition of the cluster's
+// identity or federation membership.
+type ClusterIdEvent struct {
+	Type          ClusterIdEventType
+	ClusterUID    string
+	FederationUID string
+}
+
 type ClusterId struct {
-	idLock     sync.RWMutex
-	client     clientset.Interface
-	cfgMapKey  string
-	store      cache.Store
+	idLock sync.RWMutex
+	client clientset.Interface
+
+	// ciClient talks to the ClusterIdentity CRD instance.
+	ciClient dynamic.ResourceInterface
+	ciKey    string
+	ciStore  cache.Store
+
+	// cfgMapKey/store remain so the legacy "ingress-uid" config map can
+	// still be read as a one-way migration source for clusters upgrading
+	// from before the ClusterIdentity CRD existed.
+	cfgMapKey string
+	store     cache.Store
+
 	providerId *string
 	clusterId  *string
+
+	// federationId and phase are read directly off the reconciled
+	// ClusterIdentity's Spec.FederationUID/Status.Phase. GetId and
+	// GetFederationId consult these instead of inferring federation from
+	// providerId != clusterId, so Joining/Leaving is distinguishable from
+	// a settled Federated/Standalone state.
+	federationId *string
+	phase        clusteridentity.ClusterIdentityPhase
+
+	// lastObservedAt is when the ClusterIdentity informer last delivered
+	// an add/update event; it backs gce_clusterid_informer_lag_seconds.
+	lastObservedAt time.Time
+
+	events chan ClusterIdEvent
+
+	// eventPublisher is the optional CloudEvents sink configured via
+	// "[Global] clusterid-events-sink" in the GCE cloud config. It is nil
+	// when no sink is configured, in which case publishing is a no-op.
+	eventPublisher *clusterIdEventPublisher
+
+	// derivationMode and keySecretRef come from "[Global] clusterid-derivation"
+	// and "[Global] clusterid-key-secret"; the fingerprint inputs below come
+	// from GCECloud. Together they back generateNewClusterId.
+	derivationMode  ClusterIdDerivationMode
+	keySecretRef    string
+	projectID       string
+	region          string
+	clusterName     string
+	networkSelfLink string
+
+	// restConfig is the rest.Config backing ciClient, retained so a
+	// client-go clientset can be built from it on demand (e.g. for the
+	// leader-election lease lock, which needs client-go typed clients
+	// rather than the internal generated clientset in client).
+	restConfig *restclient.Config
+}
+
+// publishCloudEvent hands a lifecycle transition to the configured sink,
+// if any, without blocking the informer goroutine that called it.
+func (ci *ClusterId) publishCloudEvent(eventType string, data CloudEventData, resourceVersion string) {
+	if ci.eventPublisher == nil {
+		return
+	}
+	ci.eventPublisher.Enqueue(CloudEvent{
+		SpecVersion: "0.2",
+		Type:        eventType,
+		Source:      clusterIdEventSource,
+		ID:          sequenceFromResourceVersion(resourceVersion),
+		Time:        time.Now(),
+		Data:        data,
+	})
+}
+
+// Events returns a channel of typed cluster identity transitions. Callers
+// (ingress, service-controller, route-controller, ...) should range over
+// this instead of polling GetId().
+func (ci *ClusterId) Events() <-chan ClusterIdEvent {
+	return ci.events
 }
 
-// Continually watches for changes to the cluser id config map
+// Continually watches for changes to the ClusterIdentity resource, and to
+// the legacy "ingress-uid" config map so it can be migrated forward.
 func (gce *GCECloud) watchClusterId() {
+	client := gce.clientBuilder.ClientOrDie("cloud-provider")
+	restConfig := gce.clientBuilder.ConfigOrDie("cloud-provider")
 	gce.ClusterId = ClusterId{
-		cfgMapKey: fmt.Sprintf("%v/%v", UIDNamespace, UIDConfigMapName),
-		client:    gce.clientBuilder.ClientOrDie("cloud-provider"),
+		cfgMapKey:  fmt.Sprintf("%v/%v", UIDNamespace, UIDConfigMapName),
+		ciKey:      ClusterIdentityName,
+		client:     client,
+		ciClient:   newClusterIdentityResourceClient(restConfig),
+		restConfig: restConfig,
+		events:     make(chan ClusterIdEvent, clusterIdEventQueueLen),
 	}
 
+	if sinkURL := gce.config.Global.ClusterIDEventsSink; sinkURL != "" {
+		gce.ClusterId.eventPublisher = newClusterIdEventPublisher(NewHTTPClusterIdEventSink(sinkURL))
+	}
+
+	gce.ClusterId.derivationMode = ClusterIdDerivationMode(gce.config.Global.ClusterIDDerivation)
+	if gce.ClusterId.derivationMode == "" {
+		gce.ClusterId.derivationMode = ClusterIdDerivationRandom
+	}
+	gce.ClusterId.keySecretRef = gce.config.Global.ClusterIDKeySecret
+	gce.ClusterId.projectID = gce.projectID
+	gce.ClusterId.region = gce.region
+	gce.ClusterId.clusterName = gce.clusterName
+	gce.ClusterId.networkSelfLink = gce.networkURL
+
 	mapEventHandler := cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			m, ok := obj.(*v1.ConfigMap)
@@ -77,8 +248,13 @@ func (gce *GCECloud) watchClusterId() {
 				return
 			}
 
-			glog.V(4).Infof("Observed new configmap for clusterid: %v, %v; setting local values", m.Name, m.Data)
-			gce.ClusterId.setIds(m)
+			glog.V(4).Infof("Observed new configmap for clusterid: %v, %v; will be used for migration only", m.Name, m.Data)
+			gce.ClusterId.publishCloudEvent(clusterIdEventUpdated, CloudEventData{
+				ClusterUID:      m.Data[UIDCluster],
+				ProviderUID:     m.Data[UIDProvider],
+				SourceNamespace: m.Namespace,
+				SourceName:      m.Name,
+			}, m.ResourceVersion)
 		},
 		UpdateFunc: func(old, cur interface{}) {
 			m, ok := cur.(*v1.ConfigMap)
@@ -96,21 +272,73 @@ func (gce *GCECloud) watchClusterId() {
 				return
 			}
 
-			glog.V(4).Infof("Observed updated configmap for clusterid %v, %v; setting local values", m.Name, m.Data)
-			gce.ClusterId.setIds(m)
+			glog.V(4).Infof("Observed updated configmap for clusterid %v, %v; config map is migration-only and no longer authoritative", m.Name, m.Data)
+			gce.ClusterId.publishCloudEvent(clusterIdEventUpdated, CloudEventData{
+				ClusterUID:      m.Data[UIDCluster],
+				ProviderUID:     m.Data[UIDProvider],
+				SourceNamespace: m.Namespace,
+				SourceName:      m.Name,
+			}, m.ResourceVersion)
+		},
+	}
+
+	ciEventHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			gce.ClusterId.reconcile(obj)
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			if reflect.DeepEqual(old, cur) {
+				return
+			}
+			gce.ClusterId.reconcile(cur)
 		},
 	}
 
-	listerWatcher := cache.NewListWatchFromClient(gce.ClusterId.client.Core().RESTClient(), "configmaps", UIDNamespace, fields.Everything())
-	var controller cache.Controller
-	gce.ClusterId.store, controller = cache.NewInformer(newSingleObjectListerWatcher(listerWatcher, UIDConfigMapName), &v1.ConfigMap{}, updateFuncFrequency, mapEventHandler)
+	listerWatcher := cache.NewListWatchFromClient(client.Core().RESTClient(), "configmaps", UIDNamespace, fields.Everything())
+	var mapController cache.Controller
+	gce.ClusterId.store, mapController = cache.NewInformer(newSingleObjectListerWatcher(listerWatcher, UIDConfigMapName), &v1.ConfigMap{}, updateFuncFrequency, mapEventHandler)
+
+	var ciController cache.Controller
+	gce.ClusterId.ciStore, ciController = cache.NewInformer(newSingleObjectListerWatcher(gce.ClusterId.ciClient, ClusterIdentityName), &unstructured.Unstructured{}, updateFuncFrequency, ciEventHandler)
+
+	go mapController.Run(nil)
+	go wait.Until(gce.ClusterId.reportInformerLag, informerLagReportInterval, wait.NeverStop)
+	ciController.Run(nil)
+}
 
-	controller.Run(nil)
+// reportInformerLag refreshes gce_clusterid_informer_lag_seconds from the
+// last time the ClusterIdentity informer delivered an event.
+func (ci *ClusterId) reportInformerLag() {
+	ci.idLock.RLock()
+	last := ci.lastObservedAt
+	ci.idLock.RUnlock()
+	if last.IsZero() {
+		return
+	}
+	clusterIdInformerLagSeconds.Set(time.Since(last).Seconds())
 }
 
-// GetId returns the id which is unique to this cluster
-// if federated, return the provider id (unique to the cluster)
-// if not federated, return the cluster id
+// reconcile applies an observed ClusterIdentity object to the in-memory
+// id cache and emits typed events for anything that changed.
+func (ci *ClusterId) reconcile(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok || u == nil {
+		glog.Errorf("Expected *unstructured.Unstructured, item=%+v, typeIsOk=%v", obj, ok)
+		return
+	}
+
+	identity, err := fromUnstructured(u)
+	if err != nil {
+		glog.Errorf("Failed to convert ClusterIdentity %v: %v", u.GetName(), err)
+		return
+	}
+
+	glog.V(4).Infof("Observed ClusterIdentity %v: uid=%v federationUID=%v phase=%v", identity.Name, identity.Spec.ClusterUID, identity.Spec.FederationUID, identity.Status.Phase)
+	ci.setIdsFromClusterIdentity(identity)
+}
+
+// GetId returns the id which is unique to this cluster: the federation id
+// while Status.Phase is Federated, otherwise the cluster id.
 func (ci *ClusterId) GetId() (string, error) {
 	if err := ci.getOrInitialize(); err != nil {
 		return "", err
@@ -122,17 +350,19 @@ func (ci *ClusterId) GetId() (string, error) {
 		return "", errors.New("Could not retrieve cluster id")
 	}
 
-	// If provider ID is set, (Federation is enabled) use this field
-	if ci.providerId != nil && *ci.providerId != *ci.clusterId {
-		return *ci.providerId, nil
+	// Federation membership is read directly off the reconciled
+	// ClusterIdentity's Status.Phase/Spec.FederationUID. A cluster mid
+	// Joining or Leaving is not yet (or no longer) Federated, so it still
+	// reports its own cluster id here.
+	if ci.phase == clusteridentity.ClusterIdentityFederated && ci.federationId != nil {
+		return *ci.federationId, nil
 	}
 
-	// providerId is not set, use the cluster id
 	return *ci.clusterId, nil
 }
 
-// GetFederationId returns the id which could represent the entire Federation
-// or just the cluster if not federated.
+// GetFederationId returns the id which could represent the entire
+// Federation, and whether this cluster is currently Federated.
 func (ci *ClusterId) GetFederationId() (string, bool, error) {
 	if err := ci.getOrInitialize(); err != nil {
 		return "", false, err
@@ -144,19 +374,125 @@ func (ci *ClusterId) GetFederationId() (string, bool, error) {
 		return "", false, errors.New("Could not retrieve cluster id")
 	}
 
-	// If provider ID is not set, return false
-	if ci.providerId == nil || *ci.clusterId == *ci.providerId {
+	if ci.phase != clusteridentity.ClusterIdentityFederated || ci.federationId == nil {
 		return "", false, nil
 	}
 
-	return *ci.clusterId, true, nil
+	return *ci.federationId, true, nil
+}
+
+// JoinFederation marks this cluster as a member of federationUID. It
+// retries on resource-version conflicts, re-reading the ClusterIdentity
+// and re-applying the change, rather than relying on the implicit
+// "providerId != clusterId" convention the config map used.
+//
+// The join happens in two updates, passing through ClusterIdentityJoining
+// before settling on ClusterIdentityFederated, so watchers relying on
+// Status.Phase (rather than just Spec.FederationUID) can distinguish a
+// federation change in flight from a already-settled one.
+func (ci *ClusterId) JoinFederation(federationUID string) error {
+	if federationUID == "" {
+		return errors.New("federationUID must not be empty")
+	}
+
+	if err := ci.updateClusterIdentity(func(identity *clusteridentity.ClusterIdentity) {
+		identity.Spec.FederationUID = federationUID
+		if !hasFederationMember(identity.Spec.Members, identity.Spec.ClusterUID) {
+			identity.Spec.Members = append(identity.Spec.Members, clusteridentity.FederationMember{
+				UID:      identity.Spec.ClusterUID,
+				Role:     clusteridentity.FederationMemberSecondary,
+				JoinedAt: metav1.Now(),
+			})
+		}
+		identity.Status.Phase = clusteridentity.ClusterIdentityJoining
+		identity.Status.Message = fmt.Sprintf("joining federation %v", federationUID)
+	}); err != nil {
+		return err
+	}
+
+	return ci.updateClusterIdentity(func(identity *clusteridentity.ClusterIdentity) {
+		identity.Status.Phase = clusteridentity.ClusterIdentityFederated
+		identity.Status.Message = fmt.Sprintf("joined federation %v", federationUID)
+	})
+}
+
+// LeaveFederation clears this cluster's federation membership, passing
+// through ClusterIdentityLeaving before settling on
+// ClusterIdentityStandalone for the same reason JoinFederation passes
+// through ClusterIdentityJoining.
+func (ci *ClusterId) LeaveFederation() error {
+	if err := ci.updateClusterIdentity(func(identity *clusteridentity.ClusterIdentity) {
+		identity.Status.Phase = clusteridentity.ClusterIdentityLeaving
+		identity.Status.Message = "leaving federation"
+	}); err != nil {
+		return err
+	}
+
+	return ci.updateClusterIdentity(func(identity *clusteridentity.ClusterIdentity) {
+		identity.Spec.FederationUID = ""
+		identity.Spec.Members = nil
+		identity.Status.Phase = clusteridentity.ClusterIdentityStandalone
+		identity.Status.Message = "left federation"
+	})
+}
+
+// hasFederationMember reports whether members already contains an entry
+// for clusterUID, so JoinFederation can avoid appending a duplicate on
+// retry or on a repeated call.
+func hasFederationMember(members []clusteridentity.FederationMember, clusterUID string) bool {
+	for _, m := range members {
+		if m.UID == clusterUID {
+			return true
+		}
+	}
+	return false
 }
 
-// getOrInitialize either grabs the configmaps current value or defines the value
-// and sets the configmap. This is for the case of the user calling GetClusterId()
-// before the watch has begun.
+// updateClusterIdentity fetches the current ClusterIdentity, applies
+// mutate, and submits the update. On a resource-version conflict it
+// re-fetches and retries, the same pattern client-go's
+// client-go/util/retry.RetryOnConflict uses elsewhere in this tree.
+func (ci *ClusterId) updateClusterIdentity(mutate func(identity *clusteridentity.ClusterIdentity)) error {
+	const maxAttempts = 5
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		u, err := ci.ciClient.Get(ci.ciKey, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		identity, err := fromUnstructured(u)
+		if err != nil {
+			return err
+		}
+
+		mutate(identity)
+
+		updated, err := toUnstructured(identity)
+		if err != nil {
+			return err
+		}
+
+		if _, err := ci.ciClient.Update(updated); err != nil {
+			if apierrors.IsConflict(err) {
+				lastErr = err
+				continue
+			}
+			return err
+		}
+
+		ci.setIdsFromClusterIdentity(identity)
+		return nil
+	}
+	return fmt.Errorf("giving up updating ClusterIdentity %v after %v attempts: %v", ci.ciKey, maxAttempts, lastErr)
+}
+
+// getOrInitialize either grabs the ClusterIdentity's current value or
+// defines the value and creates it. This is for the case of the user
+// calling GetId() before the watch has begun. When no ClusterIdentity
+// exists yet but a legacy "ingress-uid" config map does, its UIDs are
+// migrated onto the new resource instead of generating fresh ones.
 func (ci *ClusterId) getOrInitialize() error {
-	if ci.store == nil {
+	if ci.ciStore == nil {
 		return errors.New("GCECloud.ClusterId is not ready. Call Initialize() before using.")
 	}
 
@@ -164,43 +500,231 @@ func (ci *ClusterId) getOrInitialize() error {
 		return nil
 	}
 
-	exists, err := ci.getConfigMap()
-	if err != nil {
+	if exists, err := ci.getClusterIdentity(); err != nil {
 		return err
 	} else if exists {
 		return nil
 	}
 
-	// The configmap does not exist - let's try creating one.
-	newId, err := makeUID()
+	newId, providerId := ci.migrateFromConfigMap()
+	if newId == "" {
+		var err error
+		newId, err = ci.generateNewClusterId()
+		if err != nil {
+			return err
+		}
+		providerId = newId
+	} else if err := ci.checkFingerprintConsistency(newId); err != nil {
+		return err
+	}
+
+	return ci.createUnderLeaderElection(newId, providerId)
+}
+
+// generateNewClusterId picks a brand-new ClusterUID according to the
+// configured "[Global] clusterid-derivation" mode. Fingerprint mode
+// reproduces the same UID for the same project/region/cluster/network, so
+// recreating a cluster (e.g. disaster recovery) doesn't break ingress
+// name continuity or federation membership.
+func (ci *ClusterId) generateNewClusterId() (string, error) {
+	if ci.derivationMode != ClusterIdDerivationFingerprint {
+		return makeUID()
+	}
+
+	return ci.newClusterIdForFingerprintMode(ci.keySecretRef, ci.projectID, ci.region, ci.clusterName, ci.networkSelfLink)
+}
+
+// WaitForInitialized blocks until the ClusterIdentity informer has
+// observed an authoritative cluster UID, or ctx is done. Callers like the
+// ingress controller should use this instead of calling GetId() in a
+// tight retry loop while the informer is still starting up.
+func (ci *ClusterId) WaitForInitialized(ctx context.Context) error {
+	return wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		ci.idLock.RLock()
+		defer ci.idLock.RUnlock()
+		return ci.clusterId != nil, nil
+	}, ctx.Done())
+}
+
+// createUnderLeaderElection guards ClusterIdentity creation with a
+// dedicated "ingress-uid-init" Lease so that multiple kube-controller-manager
+// replicas (or HA masters) racing to initialize a fresh cluster don't all
+// attempt a Create. Only the elected leader creates; everyone else simply
+// waits for the informer to observe the winner's value.
+func (ci *ClusterId) createUnderLeaderElection(newId, providerId string) error {
+	clusterIdInitAttemptsTotal.Inc()
+
+	// resourcelock.New needs client-go's external typed clients, not the
+	// internal generated clientset this file otherwise uses, so build one
+	// from the same rest.Config on demand.
+	leaderClient, err := clientgokubernetes.NewForConfig(ci.restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to build leader election client: %v", err)
+	}
+
+	identity, err := leaderElectionIdentity()
+	if err != nil {
+		return fmt.Errorf("failed to build leader election identity: %v", err)
+	}
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		UIDNamespace,
+		clusterIdInitLeaseName,
+		leaderClient.CoreV1(),
+		leaderClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: identity},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to build %v lease lock: %v", clusterIdInitLeaseName, err)
+	}
+
+	// The whole attempt is bounded: a replica that never wins leadership
+	// must give up and fall back to observing the winner through the
+	// informer instead of blocking GetId()/getOrInitialize() forever.
+	ctx, cancel := context.WithTimeout(context.Background(), clusterIdInitElectionTimeout)
+	defer cancel()
+
+	var createErr error
+	won := make(chan struct{})
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leCtx context.Context) {
+				createErr = ci.createOrAdoptClusterIdentity(newId, providerId)
+				close(won)
+				cancel()
+			},
+			OnStoppedLeading: func() {},
+		},
+	})
 	if err != nil {
 		return err
 	}
 
-	glog.V(4).Infof("Creating clusterid: %v", newId)
-	cfg := &v1.ConfigMap{
+	// Run the elector on its own goroutine: this call must return once ctx
+	// is done (we won, or the timeout elapsed) regardless of how long
+	// le.Run itself takes to unwind its retry loop.
+	go le.Run(ctx)
+	<-ctx.Done()
+
+	select {
+	case <-won:
+		return createErr
+	default:
+		// Never won within the timeout; someone else is very likely
+		// already initializing. Wait for the informer to observe it
+		// rather than treating this as a hard, un-retryable failure.
+		return ci.waitForPeerInitialization(clusterIdInitElectionTimeout)
+	}
+}
+
+// waitForPeerInitialization polls the ClusterIdentity store, bounded by
+// timeout, for the case where another replica won createUnderLeaderElection's
+// race. It returns nil as soon as a value is observed; if timeout elapses
+// with still nothing there, callers can retry GetId() later the same way
+// they would for any other transient initialization error.
+func (ci *ClusterId) waitForPeerInitialization(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		return ci.getClusterIdentity()
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("timed out waiting %v for another replica to initialize ClusterIdentity %v: %v", timeout, ci.ciKey, err)
+	}
+	return nil
+}
+
+// createOrAdoptClusterIdentity creates the singleton ClusterIdentity. If
+// another replica won the race and created it first, the resulting
+// AlreadyExists is treated as benign: the existing object's UIDs are
+// adopted rather than the error being propagated to the caller.
+func (ci *ClusterId) createOrAdoptClusterIdentity(newId, providerId string) error {
+	glog.V(4).Infof("Creating ClusterIdentity: %v", newId)
+	identity := &clusteridentity.ClusterIdentity{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      UIDConfigMapName,
-			Namespace: UIDNamespace,
+			Name: ClusterIdentityName,
+		},
+		Spec: clusteridentity.ClusterIdentitySpec{
+			ClusterUID: newId,
+		},
+		Status: clusteridentity.ClusterIdentityStatus{
+			Phase: clusteridentity.ClusterIdentityStandalone,
 		},
 	}
-	cfg.Data = map[string]string{
-		UIDCluster:  newId,
-		UIDProvider: newId,
+
+	// Under the legacy config map convention, providerId != clusterId
+	// meant this cluster was federated. Carry that forward instead of
+	// silently dropping it the first time a migrated cluster reconciles
+	// the new CRD.
+	if providerId != "" && providerId != newId {
+		identity.Spec.FederationUID = providerId
+		identity.Spec.Members = []clusteridentity.FederationMember{{
+			UID:      newId,
+			Role:     clusteridentity.FederationMemberSecondary,
+			JoinedAt: metav1.Now(),
+		}}
+		identity.Status.Phase = clusteridentity.ClusterIdentityFederated
+		identity.Status.Message = fmt.Sprintf("migrated from legacy ingress-uid config map as a member of federation %v", providerId)
 	}
 
-	if _, err := ci.client.Core().ConfigMaps(UIDNamespace).Create(cfg); err != nil {
-		glog.Errorf("GCE cloud provider failed to create %v config map to store cluster id: %v", ci.cfgMapKey, err)
+	u, err := toUnstructured(identity)
+	if err != nil {
 		return err
 	}
 
-	glog.V(2).Infof("Created a config map containing clusterid: %v", newId)
-	ci.setIds(cfg)
+	created, err := ci.ciClient.Create(u)
+	if err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			glog.Errorf("GCE cloud provider failed to create ClusterIdentity %v: %v", ci.ciKey, err)
+			return err
+		}
+
+		clusterIdInitConflictsTotal.Inc()
+		glog.V(2).Infof("ClusterIdentity %v was already created by another replica; adopting its UIDs", ci.ciKey)
+		existing, err := ci.ciClient.Get(ci.ciKey, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		adopted, err := fromUnstructured(existing)
+		if err != nil {
+			return err
+		}
+		ci.setIdsFromClusterIdentity(adopted)
+		return nil
+	}
+
+	glog.V(2).Infof("Created ClusterIdentity containing clusterid: %v", newId)
+	ci.idLock.Lock()
+	ci.clusterId = &newId
+	ci.providerId = &providerId
+	if identity.Spec.FederationUID != "" {
+		federationUID := identity.Spec.FederationUID
+		ci.federationId = &federationUID
+	} else {
+		ci.federationId = nil
+	}
+	ci.phase = identity.Status.Phase
+	ci.lastObservedAt = time.Now()
+	ci.idLock.Unlock()
+	ci.emit(true, identity.Spec.FederationUID != "", false, newId, identity.Spec.FederationUID)
+	ci.publishCloudEvent(clusterIdEventCreated, CloudEventData{
+		ClusterUID:      newId,
+		ProviderUID:     providerId,
+		FederationUID:   identity.Spec.FederationUID,
+		SourceNamespace: metav1.NamespaceNone,
+		SourceName:      ClusterIdentityName,
+	}, created.GetResourceVersion())
 	return nil
 }
 
-func (ci *ClusterId) getConfigMap() (bool, error) {
-	item, exists, err := ci.store.GetByKey(ci.cfgMapKey)
+func (ci *ClusterId) getClusterIdentity() (bool, error) {
+	item, exists, err := ci.ciStore.GetByKey(ci.ciKey)
 	if err != nil {
 		return false, err
 	}
@@ -208,25 +732,137 @@ func (ci *ClusterId) getConfigMap() (bool, error) {
 		return false, nil
 	}
 
-	m, ok := item.(*v1.ConfigMap)
-	if !ok || m == nil {
-		err = fmt.Errorf("Expected v1.ConfigMap, item=%+v, typeIsOk=%v", item, ok)
+	u, ok := item.(*unstructured.Unstructured)
+	if !ok || u == nil {
+		err = fmt.Errorf("Expected *unstructured.Unstructured, item=%+v, typeIsOk=%v", item, ok)
 		glog.Error(err)
 		return false, err
 	}
-	ci.setIds(m)
+
+	identity, err := fromUnstructured(u)
+	if err != nil {
+		return false, err
+	}
+	ci.setIdsFromClusterIdentity(identity)
 	return true, nil
 }
 
-func (ci *ClusterId) setIds(m *v1.ConfigMap) {
+// migrateFromConfigMap reads whatever the legacy config map informer has
+// observed so far, returning empty strings if there's nothing to migrate.
+func (ci *ClusterId) migrateFromConfigMap() (clusterId, providerId string) {
+	if ci.store == nil {
+		return "", ""
+	}
+	item, exists, err := ci.store.GetByKey(ci.cfgMapKey)
+	if err != nil || !exists {
+		return "", ""
+	}
+	m, ok := item.(*v1.ConfigMap)
+	if !ok || m == nil {
+		return "", ""
+	}
+	clusterId = m.Data[UIDCluster]
+	providerId = m.Data[UIDProvider]
+	if providerId == "" {
+		providerId = clusterId
+	}
+	return clusterId, providerId
+}
+
+// setIdsFromClusterIdentity applies a reconciled ClusterIdentity to the
+// cache and emits typed events for anything that changed.
+func (ci *ClusterId) setIdsFromClusterIdentity(identity *clusteridentity.ClusterIdentity) {
+	newClusterId := identity.Spec.ClusterUID
+	newProviderId := identity.Spec.ClusterUID
+	newFederationId := identity.Spec.FederationUID
+	newPhase := identity.Status.Phase
+	if newPhase == "" {
+		newPhase = clusteridentity.ClusterIdentityStandalone
+	}
+	if newFederationId != "" {
+		newProviderId = newFederationId
+	}
+
 	ci.idLock.Lock()
-	defer ci.idLock.Unlock()
-	if clusterId, exists := m.Data[UIDCluster]; exists {
-		ci.clusterId = &clusterId
+	var oldClusterId, oldFederationId string
+	if ci.clusterId != nil {
+		oldClusterId = *ci.clusterId
+	}
+	if ci.federationId != nil {
+		oldFederationId = *ci.federationId
 	}
-	if provId, exists := m.Data[UIDProvider]; exists {
-		ci.providerId = &provId
+
+	identityChanged := oldClusterId != newClusterId
+	federationJoined := oldFederationId == "" && newFederationId != ""
+	federationLeft := oldFederationId != "" && newFederationId == ""
+
+	ci.clusterId = &newClusterId
+	ci.providerId = &newProviderId
+	if newFederationId != "" {
+		ci.federationId = &newFederationId
+	} else {
+		ci.federationId = nil
+	}
+	ci.phase = newPhase
+	ci.lastObservedAt = time.Now()
+	ci.idLock.Unlock()
+
+	ci.emit(identityChanged, federationJoined, federationLeft, newClusterId, newFederationId)
+
+	eventType := clusterIdEventUpdated
+	if federationJoined || federationLeft {
+		eventType = clusterIdEventFederationChanged
 	}
+	ci.publishCloudEvent(eventType, CloudEventData{
+		ClusterUID:         newClusterId,
+		ProviderUID:        newProviderId,
+		PreviousUID:        oldClusterId,
+		FederationUID:      newFederationId,
+		PreviousFederation: oldFederationId,
+		SourceNamespace:    identity.Namespace,
+		SourceName:         identity.Name,
+	}, identity.ResourceVersion)
+}
+
+func (ci *ClusterId) emit(identityChanged, federationJoined, federationLeft bool, clusterUID, federationUID string) {
+	if ci.events == nil {
+		return
+	}
+	send := func(t ClusterIdEventType) {
+		select {
+		case ci.events <- ClusterIdEvent{Type: t, ClusterUID: clusterUID, FederationUID: federationUID}:
+		default:
+			glog.Warningf("ClusterId event channel full, dropping %v event", t)
+		}
+	}
+	if identityChanged {
+		send(IdentityChanged)
+	}
+	if federationJoined {
+		send(FederationJoined)
+	}
+	if federationLeft {
+		send(FederationLeft)
+	}
+}
+
+// leaderElectionIdentity returns a unique per-process identity for the
+// "ingress-uid-init" lease lock. It must never be derived from the
+// candidate cluster UID: in fingerprint derivation mode that value is a
+// pure function of project/region/cluster/network and is therefore
+// identical across every replica, which would make resourcelock's
+// HolderIdentity equality check mistake every replica for the leader as
+// soon as one of them writes the lease.
+func leaderElectionIdentity() (string, error) {
+	suffix, err := makeUID()
+	if err != nil {
+		return "", err
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return suffix, nil
+	}
+	return host + "_" + suffix, nil
 }
 
 func makeUID() (string, error) {
@@ -238,6 +874,41 @@ func makeUID() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+func fromUnstructured(u *unstructured.Unstructured) (*clusteridentity.ClusterIdentity, error) {
+	identity := &clusteridentity.ClusterIdentity{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, identity); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func toUnstructured(identity *clusteridentity.ClusterIdentity) (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(identity)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// newClusterIdentityResourceClient builds a dynamic REST client scoped to
+// the ClusterIdentity CRD. A dynamic client is used rather than a
+// generated typed clientset because ClusterIdentity is registered as a
+// CustomResourceDefinition and may not exist in every cluster.
+func newClusterIdentityResourceClient(cfg *restclient.Config) dynamic.ResourceInterface {
+	dynCfg := *cfg
+	dynCfg.GroupVersion = &clusteridentity.SchemeGroupVersion
+	dynCfg.APIPath = "/apis"
+	dynClient, err := dynamic.NewClient(&dynCfg)
+	if err != nil {
+		glog.Fatalf("Failed to build ClusterIdentity client: %v", err)
+	}
+	return dynClient.Resource(&metav1.APIResource{
+		Name:       "clusteridentities",
+		Kind:       "ClusterIdentity",
+		Namespaced: false,
+	}, metav1.NamespaceNone)
+}
+
 func newSingleObjectListerWatcher(lw cache.ListerWatcher, objectName string) *singleObjListerWatcher {
 	return &singleObjListerWatcher{lw: lw, objectName: objectName}
 }
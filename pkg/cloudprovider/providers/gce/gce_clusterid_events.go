@@ -0,0 +1,208 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// clusterIdEventSource identifies the CloudEvents "source" field for
+	// every event this package publishes.
+	clusterIdEventSource = "urn:kubernetes:cloudprovider:gce:clusterid"
+
+	// clusterIdEventCreated fires when getOrInitialize itself creates the
+	// singleton ClusterIdentity. Adopting one another replica created is
+	// published as clusterIdEventUpdated (or clusterIdEventFederationChanged)
+	// instead.
+	clusterIdEventCreated = "com.kubernetes.gce.clusterid.v1.created"
+	// clusterIdEventUpdated fires on every subsequent add/update of the
+	// ClusterIdentity (or, during migration, the legacy config map).
+	clusterIdEventUpdated = "com.kubernetes.gce.clusterid.v1.updated"
+	// clusterIdEventFederationChanged fires when FederationUID transitions
+	// either into or out of being set.
+	clusterIdEventFederationChanged = "com.kubernetes.gce.clusterid.v1.federation_changed"
+
+	// clusterIdEventQueueCap bounds the in-memory queue between the
+	// informer event handlers and the (possibly slow) configured sink.
+	clusterIdEventQueueCap = 256
+
+	sinkInitialBackoff = 500 * time.Millisecond
+	sinkMaxBackoff     = 30 * time.Second
+	sinkMaxAttempts    = 5
+)
+
+// CloudEvent is the payload published for a cluster identity transition.
+// Its shape follows the CloudEvents HTTP binding used elsewhere for
+// open-cluster-management/knative-style notifications.
+type CloudEvent struct {
+	// SpecVersion is the CloudEvents spec version this event was encoded with.
+	SpecVersion string `json:"specversion"`
+	// Type is one of the clusterIdEvent* constants above.
+	Type string `json:"type"`
+	// Source identifies the component that published the event.
+	Source string `json:"source"`
+	// ID is a monotonic sequence number derived from the ClusterIdentity's
+	// ResourceVersion, used by consumers to detect gaps or reordering.
+	ID string `json:"id"`
+	// Time is when the transition was observed.
+	Time time.Time `json:"time"`
+	// Data carries the details of the transition.
+	Data CloudEventData `json:"data"`
+}
+
+// CloudEventData is the body of a ClusterId CloudEvent.
+type CloudEventData struct {
+	ClusterUID         string `json:"clusterUID"`
+	ProviderUID        string `json:"providerUID"`
+	PreviousUID        string `json:"previousUID,omitempty"`
+	FederationUID      string `json:"federationUID,omitempty"`
+	PreviousFederation string `json:"previousFederationUID,omitempty"`
+	SourceNamespace    string `json:"sourceNamespace"`
+	SourceName         string `json:"sourceName"`
+}
+
+// ClusterIdEventSink publishes a CloudEvent somewhere. Implementations
+// must be safe for concurrent use.
+type ClusterIdEventSink interface {
+	Publish(ctx context.Context, event CloudEvent) error
+}
+
+// NewHTTPClusterIdEventSink returns a ClusterIdEventSink that POSTs each
+// event, CloudEvents-HTTP-binding-encoded, to url.
+func NewHTTPClusterIdEventSink(url string) ClusterIdEventSink {
+	return &httpClusterIdEventSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type httpClusterIdEventSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpClusterIdEventSink) Publish(ctx context.Context, event CloudEvent) error {
+	body, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("ce-specversion", event.SpecVersion)
+	req.Header.Set("ce-type", event.Type)
+	req.Header.Set("ce-source", event.Source)
+	req.Header.Set("ce-id", event.ID)
+	req.Header.Set("ce-time", event.Time.Format(time.RFC3339Nano))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("clusterid event sink %v returned status %v", s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// clusterIdEventPublisher decouples informer event handlers from the
+// configured sink: Enqueue never blocks, and a single worker goroutine
+// drains the bounded queue with retry+backoff so a slow or unreachable
+// sink cannot stall reconciliation.
+type clusterIdEventPublisher struct {
+	sink  ClusterIdEventSink
+	queue chan CloudEvent
+}
+
+func newClusterIdEventPublisher(sink ClusterIdEventSink) *clusterIdEventPublisher {
+	p := &clusterIdEventPublisher{
+		sink:  sink,
+		queue: make(chan CloudEvent, clusterIdEventQueueCap),
+	}
+	go p.run()
+	return p
+}
+
+// Enqueue queues event for publishing, dropping it (with a log line)
+// rather than blocking the caller if the queue is full.
+func (p *clusterIdEventPublisher) Enqueue(event CloudEvent) {
+	if p == nil {
+		return
+	}
+	select {
+	case p.queue <- event:
+	default:
+		glog.Warningf("clusterid event queue full, dropping %v event %v", event.Type, event.ID)
+	}
+}
+
+func (p *clusterIdEventPublisher) run() {
+	for event := range p.queue {
+		p.publishWithRetry(event)
+	}
+}
+
+func (p *clusterIdEventPublisher) publishWithRetry(event CloudEvent) {
+	backoff := sinkInitialBackoff
+	for attempt := 1; attempt <= sinkMaxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := p.sink.Publish(ctx, event)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		glog.Errorf("clusterid event sink publish of %v %v failed (attempt %v/%v): %v", event.Type, event.ID, attempt, sinkMaxAttempts, err)
+		if attempt == sinkMaxAttempts {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > sinkMaxBackoff {
+			backoff = sinkMaxBackoff
+		}
+	}
+}
+
+// sequenceFromResourceVersion turns a ResourceVersion into the monotonic
+// sequence number CloudEvent.ID carries. ResourceVersion is opaque in
+// general, but on every apiserver that backs this in etcd it is a
+// decimal, monotonically increasing integer; zero-padding it to a fixed
+// width lets consumers compare sequence numbers lexicographically, not
+// just numerically.
+func sequenceFromResourceVersion(resourceVersion string) string {
+	seq, err := strconv.ParseUint(resourceVersion, 10, 64)
+	if err != nil {
+		glog.Warningf("ResourceVersion %q is not a monotonic integer; CloudEvent id will not be comparable to others", resourceVersion)
+		return resourceVersion
+	}
+	return fmt.Sprintf("%020d", seq)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSequenceFromResourceVersion(t *testing.T) {
+	cases := []struct {
+		name            string
+		resourceVersion string
+		want            string
+	}{
+		{"typical", "42", "00000000000000000042"},
+		{"zero", "0", "00000000000000000000"},
+		{"large", "18446744073709551615", "18446744073709551615"},
+		{"empty returned verbatim", "", ""},
+		{"non-numeric returned verbatim", "not-a-number", "not-a-number"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := sequenceFromResourceVersion(c.resourceVersion); got != c.want {
+				t.Errorf("sequenceFromResourceVersion(%q) = %q, want %q", c.resourceVersion, got, c.want)
+			}
+		})
+	}
+}
+
+// failingSink always fails Publish and counts how many times it was
+// called, so tests can assert on the retry loop's attempt count.
+type failingSink struct {
+	attempts int32
+}
+
+func (s *failingSink) Publish(ctx context.Context, event CloudEvent) error {
+	atomic.AddInt32(&s.attempts, 1)
+	return errSimulatedSinkFailure
+}
+
+var errSimulatedSinkFailure = errors.New("simulated sink failure")
+
+func TestPublishWithRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	sink := &failingSink{}
+	p := &clusterIdEventPublisher{sink: sink}
+
+	p.publishWithRetry(CloudEvent{Type: clusterIdEventCreated, ID: "1"})
+
+	if got := atomic.LoadInt32(&sink.attempts); got != sinkMaxAttempts {
+		t.Fatalf("sink.Publish called %v times, want %v", got, sinkMaxAttempts)
+	}
+}
+
+func TestEnqueueDropsWhenQueueFull(t *testing.T) {
+	// Built directly, bypassing newClusterIdEventPublisher, so no worker
+	// goroutine drains the queue and it can be driven to capacity.
+	p := &clusterIdEventPublisher{queue: make(chan CloudEvent, 2)}
+
+	p.Enqueue(CloudEvent{ID: "1"})
+	p.Enqueue(CloudEvent{ID: "2"})
+	if got := len(p.queue); got != 2 {
+		t.Fatalf("queue length = %v, want 2 after filling to capacity", got)
+	}
+
+	// The queue is full; this must not block, and the event must be
+	// dropped rather than displacing anything already queued.
+	p.Enqueue(CloudEvent{ID: "3"})
+	if got := len(p.queue); got != 2 {
+		t.Fatalf("queue length = %v after enqueueing past capacity, want still 2", got)
+	}
+	if first := <-p.queue; first.ID != "1" {
+		t.Fatalf("first queued event ID = %v, want 1 (event 3 should have been dropped)", first.ID)
+	}
+}
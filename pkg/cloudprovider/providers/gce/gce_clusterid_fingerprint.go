@@ -0,0 +1,155 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterIdDerivationMode selects how getOrInitialize picks a brand-new
+// ClusterUID when no ClusterIdentity or legacy config map exists yet.
+type ClusterIdDerivationMode string
+
+const (
+	// ClusterIdDerivationRandom generates the UID with crypto/rand. This
+	// is the default and matches the historical behavior of makeUID.
+	ClusterIdDerivationRandom ClusterIdDerivationMode = "random"
+	// ClusterIdDerivationFingerprint derives the UID deterministically
+	// from the GCE project/region/cluster/network fingerprint, so
+	// recreating a cluster in the same place reproduces the same UID.
+	ClusterIdDerivationFingerprint ClusterIdDerivationMode = "fingerprint"
+)
+
+// projectIDPattern and clusterNamePattern mirror the validators the GKE
+// provider applies to these same fields, and neither admits the "/" that
+// joins the fingerprint input. Region and network are not validated.
+var (
+	projectIDPattern   = regexp.MustCompile(`^[a-z][-a-z0-9]{4,28}[a-z0-9]$`)
+	clusterNamePattern = regexp.MustCompile(`^[a-z0-9](?:[-a-z0-9]{0,38}[a-z0-9])?$`)
+)
+
+// validateFingerprintInputs rejects project/cluster names that contain
+// the "/" fingerprint separator or otherwise fall outside GKE's naming
+// rules, either of which could let two distinct clusters collide onto
+// the same derived UID.
+func validateFingerprintInputs(projectID, clusterName string) error {
+	if !projectIDPattern.MatchString(projectID) {
+		return fmt.Errorf("project ID %q is not valid for deterministic clusterid derivation", projectID)
+	}
+	if !clusterNamePattern.MatchString(clusterName) {
+		return fmt.Errorf("cluster name %q is not valid for deterministic clusterid derivation", clusterName)
+	}
+	if strings.Contains(projectID, "/") || strings.Contains(clusterName, "/") {
+		return fmt.Errorf("project ID and cluster name must not contain %q", "/")
+	}
+	return nil
+}
+
+// deriveFingerprintUID computes the first UIDLengthBytes bytes of
+// HMAC-SHA256(key, projectID/region/clusterName/networkSelfLink) as a hex
+// string, matching the UID shape makeUID produces.
+func deriveFingerprintUID(key []byte, projectID, region, clusterName, networkSelfLink string) string {
+	msg := strings.Join([]string{projectID, region, clusterName, networkSelfLink}, "/")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	sum := mac.Sum(nil)
+	return hex.EncodeToString(sum[:UIDLengthBytes])
+}
+
+// loadClusterIdKeySecret fetches the HMAC key material referenced by
+// "[Global] clusterid-key-secret=<namespace>/<name>" out of its Secret's
+// "key" data entry.
+func (ci *ClusterId) loadClusterIdKeySecret(secretRef string) ([]byte, error) {
+	ns, name, err := splitSecretRef(secretRef)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ci.client.Core().Secrets(ns).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load clusterid derivation key from secret %v/%v: %v", ns, name, err)
+	}
+
+	key, ok := secret.Data["key"]
+	if !ok || len(key) == 0 {
+		return nil, fmt.Errorf("secret %v/%v has no \"key\" data entry", ns, name)
+	}
+	return key, nil
+}
+
+func splitSecretRef(ref string) (namespace, name string, err error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("clusterid-key-secret must be of the form <namespace>/<name>, got %q", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+// newClusterIdForFingerprintMode computes the deterministic UID for this
+// cluster from its project/region/cluster/network fingerprint. Callers
+// that already have a pre-existing UID to reconcile against (e.g. a
+// legacy "ingress-uid" config map) should run it through
+// checkFingerprintConsistency instead of, or in addition to, this.
+func (ci *ClusterId) newClusterIdForFingerprintMode(keySecretRef, projectID, region, clusterName, networkSelfLink string) (string, error) {
+	if err := validateFingerprintInputs(projectID, clusterName); err != nil {
+		return "", err
+	}
+
+	key, err := ci.loadClusterIdKeySecret(keySecretRef)
+	if err != nil {
+		return "", err
+	}
+
+	return deriveFingerprintUID(key, projectID, region, clusterName, networkSelfLink), nil
+}
+
+// checkFingerprintConsistency refuses to silently adopt existingId (the
+// UID migrated from a legacy "ingress-uid" config map) when fingerprint
+// derivation is enabled and would have computed a different UID for this
+// cluster. That mismatch almost always means the project/region/cluster
+// name/network or clusterid-key-secret changed out from under an
+// existing cluster, and blindly adopting existingId would silently
+// re-identify it.
+func (ci *ClusterId) checkFingerprintConsistency(existingId string) error {
+	if ci.derivationMode != ClusterIdDerivationFingerprint {
+		return nil
+	}
+
+	derived, err := ci.newClusterIdForFingerprintMode(ci.keySecretRef, ci.projectID, ci.region, ci.clusterName, ci.networkSelfLink)
+	if err != nil {
+		return err
+	}
+	if derived == existingId {
+		return nil
+	}
+
+	glog.Errorf(
+		"refusing to initialize: existing %v/%v config map has uid %q but fingerprint derivation computed %q; "+
+			"this usually means the project/region/cluster name/network or clusterid-key-secret changed for an existing cluster",
+		UIDNamespace, UIDConfigMapName, existingId, derived)
+	return fmt.Errorf(
+		"existing %v/%v config map uid %q does not match fingerprint-derived uid %q",
+		UIDNamespace, UIDConfigMapName, existingId, derived)
+}
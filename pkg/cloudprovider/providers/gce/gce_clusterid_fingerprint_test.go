@@ -0,0 +1,69 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import "testing"
+
+func TestValidateFingerprintInputs(t *testing.T) {
+	cases := []struct {
+		name        string
+		projectID   string
+		clusterName string
+		wantErr     bool
+	}{
+		{"valid", "my-project-1", "my-cluster", false},
+		{"project too short", "ab", "my-cluster", true},
+		{"project upper case", "My-Project", "my-cluster", true},
+		{"cluster name starts with dash", "my-project-1", "-my-cluster", true},
+		{"project contains slash", "my/project", "my-cluster", true},
+		{"cluster name contains slash", "my-project-1", "my/cluster", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateFingerprintInputs(c.projectID, c.clusterName)
+			if (err != nil) != c.wantErr {
+				t.Errorf("validateFingerprintInputs(%q, %q) error = %v, wantErr %v", c.projectID, c.clusterName, err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeriveFingerprintUID(t *testing.T) {
+	key := []byte("test-key")
+
+	uid := deriveFingerprintUID(key, "my-project-1", "us-central1", "my-cluster", "https://www.googleapis.com/compute/v1/projects/my-project-1/global/networks/default")
+
+	if len(uid) != UIDLengthBytes*2 {
+		t.Fatalf("deriveFingerprintUID returned UID of length %v, want %v", len(uid), UIDLengthBytes*2)
+	}
+
+	again := deriveFingerprintUID(key, "my-project-1", "us-central1", "my-cluster", "https://www.googleapis.com/compute/v1/projects/my-project-1/global/networks/default")
+	if uid != again {
+		t.Errorf("deriveFingerprintUID is not deterministic: %v != %v", uid, again)
+	}
+
+	diffNetwork := deriveFingerprintUID(key, "my-project-1", "us-central1", "my-cluster", "https://www.googleapis.com/compute/v1/projects/my-project-1/global/networks/other")
+	if uid == diffNetwork {
+		t.Errorf("deriveFingerprintUID did not change with a different network")
+	}
+
+	diffKey := deriveFingerprintUID([]byte("other-key"), "my-project-1", "us-central1", "my-cluster", "https://www.googleapis.com/compute/v1/projects/my-project-1/global/networks/default")
+	if uid == diffKey {
+		t.Errorf("deriveFingerprintUID did not change with a different key")
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2014 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"testing"
+
+	clusteridentity "k8s.io/kubernetes/pkg/apis/clusteridentity/v1alpha1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSetIdsFromClusterIdentityTransitions(t *testing.T) {
+	newIdentity := func(clusterUID, federationUID string, phase clusteridentity.ClusterIdentityPhase) *clusteridentity.ClusterIdentity {
+		return &clusteridentity.ClusterIdentity{
+			Spec: clusteridentity.ClusterIdentitySpec{
+				ClusterUID:    clusterUID,
+				FederationUID: federationUID,
+			},
+			Status: clusteridentity.ClusterIdentityStatus{
+				Phase: phase,
+			},
+		}
+	}
+
+	// getOrInitialize refuses to run without a store, so give it an empty
+	// one; the ids below are set directly rather than observed through it.
+	ci := &ClusterId{ciStore: cache.NewStore(cache.MetaNamespaceKeyFunc)}
+	ci.setIdsFromClusterIdentity(newIdentity("cluster-a", "", clusteridentity.ClusterIdentityStandalone))
+	if got, _ := ci.GetId(); got != "cluster-a" {
+		t.Fatalf("after initial standalone identity, GetId() = %q, want %q", got, "cluster-a")
+	}
+	if ci.phase != clusteridentity.ClusterIdentityStandalone {
+		t.Fatalf("phase = %v, want Standalone", ci.phase)
+	}
+
+	ci.setIdsFromClusterIdentity(newIdentity("cluster-a", "federation-x", clusteridentity.ClusterIdentityFederated))
+	if ci.federationId == nil || *ci.federationId != "federation-x" {
+		t.Fatalf("after joining federation, federationId = %v, want %q", ci.federationId, "federation-x")
+	}
+	if got, federated, _ := ci.GetFederationId(); got != "federation-x" || !federated {
+		t.Fatalf("GetFederationId() = %q, %v, want %q, true", got, federated, "federation-x")
+	}
+
+	ci.setIdsFromClusterIdentity(newIdentity("cluster-a", "", clusteridentity.ClusterIdentityStandalone))
+	if ci.federationId != nil {
+		t.Fatalf("after leaving federation, federationId = %v, want nil", ci.federationId)
+	}
+	if _, federated, err := ci.GetFederationId(); err != nil || federated {
+		t.Fatalf("GetFederationId() after leaving federation = federated %v, err %v; want not federated, no error", federated, err)
+	}
+
+	ci.setIdsFromClusterIdentity(newIdentity("cluster-b", "", clusteridentity.ClusterIdentityStandalone))
+	if got, _ := ci.GetId(); got != "cluster-b" {
+		t.Fatalf("after ClusterUID change, GetId() = %q, want %q", got, "cluster-b")
+	}
+}
+
+// fakeResourceInterface is a minimal dynamic.ResourceInterface that lets
+// updateClusterIdentity's conflict-retry loop be exercised without a real
+// apiserver. Only Get and Update are ever called by that loop; the rest
+// are unused stubs required to satisfy the interface.
+type fakeResourceInterface struct {
+	object    *unstructured.Unstructured
+	conflicts int
+	updates   int
+}
+
+func (f *fakeResourceInterface) Get(name string, opts metav1.GetOptions) (*unstructured.Unstructured, error) {
+	return f.object.DeepCopy(), nil
+}
+
+func (f *fakeResourceInterface) Update(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	f.updates++
+	if f.updates <= f.conflicts {
+		return nil, apierrors.NewConflict(schema.GroupResource{Resource: "clusteridentities"}, obj.GetName(), nil)
+	}
+	f.object = obj.DeepCopy()
+	return f.object, nil
+}
+
+func (f *fakeResourceInterface) Create(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	f.object = obj.DeepCopy()
+	return f.object, nil
+}
+
+func (f *fakeResourceInterface) Delete(name string, opts *metav1.DeleteOptions) error {
+	return nil
+}
+
+func (f *fakeResourceInterface) DeleteCollection(deleteOptions *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	return nil
+}
+
+func (f *fakeResourceInterface) List(opts metav1.ListOptions) (runtime.Object, error) {
+	return &unstructured.UnstructuredList{}, nil
+}
+
+func (f *fakeResourceInterface) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return watch.NewFake(), nil
+}
+
+func (f *fakeResourceInterface) Patch(name string, pt types.PatchType, data []byte) (*unstructured.Unstructured, error) {
+	return f.object.DeepCopy(), nil
+}
+
+func TestUpdateClusterIdentityRetriesOnConflict(t *testing.T) {
+	identity := &clusteridentity.ClusterIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: ClusterIdentityName},
+		Spec:       clusteridentity.ClusterIdentitySpec{ClusterUID: "cluster-a"},
+		Status:     clusteridentity.ClusterIdentityStatus{Phase: clusteridentity.ClusterIdentityStandalone},
+	}
+	u, err := toUnstructured(identity)
+	if err != nil {
+		t.Fatalf("toUnstructured: %v", err)
+	}
+
+	fake := &fakeResourceInterface{object: u, conflicts: 2}
+	ci := &ClusterId{ciClient: fake, ciKey: ClusterIdentityName}
+
+	err = ci.updateClusterIdentity(func(identity *clusteridentity.ClusterIdentity) {
+		identity.Status.Phase = clusteridentity.ClusterIdentityJoining
+	})
+	if err != nil {
+		t.Fatalf("updateClusterIdentity returned error after retrying conflicts: %v", err)
+	}
+	if fake.updates != 3 {
+		t.Fatalf("Update called %v times, want 3 (2 conflicts + 1 success)", fake.updates)
+	}
+	if ci.phase != clusteridentity.ClusterIdentityJoining {
+		t.Fatalf("phase after update = %v, want Joining", ci.phase)
+	}
+}
+
+func TestUpdateClusterIdentityGivesUpAfterMaxAttempts(t *testing.T) {
+	identity := &clusteridentity.ClusterIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: ClusterIdentityName},
+		Spec:       clusteridentity.ClusterIdentitySpec{ClusterUID: "cluster-a"},
+	}
+	u, err := toUnstructured(identity)
+	if err != nil {
+		t.Fatalf("toUnstructured: %v", err)
+	}
+
+	fake := &fakeResourceInterface{object: u, conflicts: 100}
+	ci := &ClusterId{ciClient: fake, ciKey: ClusterIdentityName}
+
+	if err := ci.updateClusterIdentity(func(identity *clusteridentity.ClusterIdentity) {}); err == nil {
+		t.Fatalf("updateClusterIdentity succeeded despite permanent conflicts")
+	}
+}